@@ -0,0 +1,87 @@
+package dht
+
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	kb "github.com/libp2p/go-libp2p-kbucket"
+)
+
+// closestWindow tracks the evolving top-k closest-peer window shared by the
+// streaming lookups (GetClosestPeersExtended, and GetClosestPeersDisjoint,
+// whose paths all fold into one window): which peers are currently inside the window, which of
+// those have already been emitted, and the RTT/hop-lineage metadata needed
+// to build a ClosestPeer for each new arrival.
+//
+// A closestWindow is not safe for concurrent use: callers processing
+// concurrent findPeerSingle responses must serialize calls to advance with
+// their own mutex.
+type closestWindow struct {
+	key     string
+	k       int
+	window  []peer.ID
+	emitted map[peer.ID]struct{}
+	hops    map[peer.ID]int
+	rtts    map[peer.ID]time.Duration
+}
+
+// newClosestWindow seeds the window's hop lineage with the initial seeds a
+// lookup started from: each seed is its own hop origin.
+func newClosestWindow(key string, k int, seeds []peer.ID) *closestWindow {
+	hops := make(map[peer.ID]int, len(seeds))
+	for i, p := range seeds {
+		hops[p] = i
+	}
+	return &closestWindow{
+		key:     key,
+		k:       k,
+		emitted: make(map[peer.ID]struct{}),
+		window:  make([]peer.ID, 0, k),
+		hops:    hops,
+		rtts:    make(map[peer.ID]time.Duration, len(seeds)),
+	}
+}
+
+// advance folds a findPeerSingle response from p (answered in rtt, having
+// disclosed discovered) into the window. discovered peers inherit p's hop
+// origin unless they already have one. It returns, in sorted order of XOR
+// distance to key, the peers that newly entered the top-k window since the
+// last call, and whether the window has stabilized (full and nothing new
+// to emit), meaning the lookup can stop early.
+func (w *closestWindow) advance(p peer.ID, rtt time.Duration, discovered []peer.ID) (toEmit []*ClosestPeer, done bool) {
+	parentHop, ok := w.hops[p]
+	if !ok {
+		parentHop = -1
+	}
+	for _, id := range discovered {
+		if _, ok := w.hops[id]; !ok {
+			w.hops[id] = parentHop
+		}
+		if _, ok := w.rtts[id]; !ok {
+			w.rtts[id] = rtt
+		}
+		if !containsPeer(w.window, id) {
+			w.window = append(w.window, id)
+		}
+	}
+	w.window = kb.SortClosestPeers(w.window, kb.ConvertKey(w.key))
+	if len(w.window) > w.k {
+		w.window = w.window[:w.k]
+	}
+
+	// emitted tracks exactly the peers in the current window: a peer
+	// pushed back out of the top-k window is dropped here too, so if it
+	// re-enters the window later it is emitted again.
+	inWindow := make(map[peer.ID]struct{}, len(w.window))
+	for _, cp := range w.window {
+		inWindow[cp] = struct{}{}
+		if _, ok := w.emitted[cp]; ok {
+			continue
+		}
+		toEmit = append(toEmit, &ClosestPeer{ID: cp, RTT: w.rtts[cp], Hop: w.hops[cp]})
+	}
+	w.emitted = inWindow
+	done = len(w.window) >= w.k && len(toEmit) == 0
+	return toEmit, done
+}
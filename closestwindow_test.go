@@ -0,0 +1,110 @@
+package dht
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestClosestWindowAdvanceEmitsInSortedOrder(t *testing.T) {
+	key := "test-key"
+	seeds := []peer.ID{"seed-0"}
+	w := newClosestWindow(key, 3, seeds)
+
+	toEmit, _ := w.advance("seed-0", 10*time.Millisecond, []peer.ID{"c", "a", "b"})
+
+	if len(toEmit) != 3 {
+		t.Fatalf("expected all 3 discovered peers to be emitted, got %d", len(toEmit))
+	}
+	want := sortedByXORDistance(key, []peer.ID{"c", "a", "b"})
+	for i, cp := range toEmit {
+		if cp.ID != want[i] {
+			t.Fatalf("expected emission order %v, got %v at index %d (full: %v)", want, cp.ID, i, toEmit)
+		}
+	}
+}
+
+func TestClosestWindowAdvancePropagatesHopFromParent(t *testing.T) {
+	key := "test-key"
+	seeds := []peer.ID{"seed-0", "seed-1"}
+	w := newClosestWindow(key, 10, seeds)
+
+	// seed-1 (hop 1) discovers "child"; "child" should inherit hop 1, not
+	// be treated as hop -1 just because it wasn't one of the original seeds.
+	toEmit, _ := w.advance("seed-1", 5*time.Millisecond, []peer.ID{"child"})
+	if len(toEmit) != 1 || toEmit[0].ID != peer.ID("child") {
+		t.Fatalf("expected child to be emitted, got %v", toEmit)
+	}
+	if toEmit[0].Hop != 1 {
+		t.Fatalf("expected child to inherit hop 1 from seed-1, got %d", toEmit[0].Hop)
+	}
+
+	// child, in turn, discovers "grandchild": hop lineage should continue
+	// to propagate rather than resetting once we're past the seed round.
+	toEmit, _ = w.advance("child", 5*time.Millisecond, []peer.ID{"grandchild"})
+	if len(toEmit) != 1 || toEmit[0].ID != peer.ID("grandchild") {
+		t.Fatalf("expected grandchild to be emitted, got %v", toEmit)
+	}
+	if toEmit[0].Hop != 1 {
+		t.Fatalf("expected grandchild to inherit hop 1 transitively, got %d", toEmit[0].Hop)
+	}
+}
+
+func TestClosestWindowAdvanceReemitsAfterFallingOutOfWindow(t *testing.T) {
+	key := "test-key"
+	seeds := []peer.ID{"seed-0"}
+	w := newClosestWindow(key, 1, seeds) // k=1: window only ever keeps the single closest peer
+
+	sorted := sortedByXORDistance(key, []peer.ID{"near", "far"})
+	near, far := sorted[0], sorted[1]
+
+	toEmit, _ := w.advance("seed-0", time.Millisecond, []peer.ID{far})
+	if len(toEmit) != 1 || toEmit[0].ID != far {
+		t.Fatalf("expected far to be emitted first, got %v", toEmit)
+	}
+
+	// near displaces far out of the k=1 window; far is no longer "emitted"
+	// as far as the window is concerned.
+	toEmit, _ = w.advance("seed-0", time.Millisecond, []peer.ID{near})
+	if len(toEmit) != 1 || toEmit[0].ID != near {
+		t.Fatalf("expected near to displace far and be emitted, got %v", toEmit)
+	}
+
+	// If far were ever rediscovered and re-entered the window, it must be
+	// emitted again rather than being suppressed forever.
+	toEmit, _ = w.advance("seed-0", time.Millisecond, []peer.ID{far})
+	if len(toEmit) != 1 || toEmit[0].ID != far {
+		t.Fatalf("expected far to be re-emitted after re-entering the window, got %v", toEmit)
+	}
+}
+
+func TestClosestWindowAdvanceSignalsDoneOnceStable(t *testing.T) {
+	key := "test-key"
+	seeds := []peer.ID{"seed-0"}
+	w := newClosestWindow(key, 2, seeds)
+
+	_, done := w.advance("seed-0", time.Millisecond, []peer.ID{"a", "b"})
+	if done {
+		t.Fatalf("did not expect done on the round that fills the window")
+	}
+
+	// Nothing new discovered, window already full and stable: safe to stop.
+	_, done = w.advance("seed-0", time.Millisecond, nil)
+	if !done {
+		t.Fatalf("expected done once the window is full and stable")
+	}
+}
+
+// sortedByXORDistance mirrors the XOR-distance ordering advance() applies
+// internally, so tests can compute the expected emission order without
+// reaching into kbucket internals directly.
+func sortedByXORDistance(key string, ids []peer.ID) []peer.ID {
+	w := newClosestWindow(key, len(ids), nil)
+	toEmit, _ := w.advance("probe", 0, ids)
+	sorted := make([]peer.ID, len(toEmit))
+	for i, cp := range toEmit {
+		sorted[i] = cp.ID
+	}
+	return sorted
+}
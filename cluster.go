@@ -0,0 +1,225 @@
+package dht
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	kb "github.com/libp2p/go-libp2p-kbucket"
+)
+
+// ClusterLevel describes one tier of a Coral-style locality hierarchy: a
+// routing table that only admits peers the node has measured to be within
+// RTTThreshold, plus the point at which the level considers itself full for
+// a given key.
+type ClusterLevel struct {
+	// Name identifies the level in logs and QueryEvents, e.g. "local",
+	// "regional", "global".
+	Name string
+
+	// RTTThreshold is the maximum measured round-trip time a peer may have
+	// to be admitted into this level's routing table. The outermost level
+	// should leave this at 0 to mean "no limit".
+	RTTThreshold time.Duration
+
+	// ProviderThreshold is the number of providers this node will store
+	// locally for a single key at this level before it declines further
+	// PutValue/Provide writes and redirects the writer to the next level
+	// out. Zero means unlimited.
+	ProviderThreshold int
+}
+
+// ClusterConfig configures the hierarchical clustering layer. Levels must
+// be ordered from innermost (smallest diameter, e.g. L0/local) to outermost
+// (largest diameter, e.g. L2/global); both reads and write admission walk
+// the levels in that order, so the outermost level is consulted last.
+type ClusterConfig struct {
+	Levels []ClusterLevel
+}
+
+// DefaultClusterConfig mirrors Coral's L0/L1/L2 diameters: a local cluster
+// for same-datacenter peers, a regional cluster for same-continent peers,
+// and an unbounded global cluster that falls back to the full routing
+// table.
+func DefaultClusterConfig() ClusterConfig {
+	return ClusterConfig{
+		Levels: []ClusterLevel{
+			{Name: "local", RTTThreshold: 30 * time.Millisecond, ProviderThreshold: 4},
+			{Name: "regional", RTTThreshold: 200 * time.Millisecond, ProviderThreshold: 16},
+			{Name: "global", RTTThreshold: 0, ProviderThreshold: 0},
+		},
+	}
+}
+
+// clusterPeerTable is the subset of *kb.RoutingTable a clusterLevelTable
+// needs. It exists so tests can drive getClosestPeersClustered against a
+// fake table without spinning up a real routing table and peerstore.
+type clusterPeerTable interface {
+	NearestPeers(kb.ID, int) []peer.ID
+	Update(peer.ID) error
+	Remove(peer.ID)
+}
+
+// clusterLevelTable is a ClusterLevel paired with its own routing table,
+// populated only with peers admitted by the level's RTT threshold.
+type clusterLevelTable struct {
+	ClusterLevel
+	routingTable clusterPeerTable
+
+	mu        sync.Mutex
+	providers map[string]int // key -> number of providers this node holds locally at this level
+}
+
+// clusterHierarchy multiplexes a node's routing state across the levels of
+// a ClusterConfig, from innermost to outermost.
+type clusterHierarchy struct {
+	dht    *IpfsDHT
+	levels []*clusterLevelTable
+}
+
+// EnableClustering layers the Coral-style hierarchy described by cfg on top
+// of dht: once enabled, GetClosestPeers consults the smallest populated
+// cluster before falling back to the global ring. AdmitWrite is the
+// matching admission-control hook for the write side, but PutValue and
+// Provide are not part of this tree slice, so nothing currently calls it —
+// wiring it in is for whoever adds those methods.
+func (dht *IpfsDHT) EnableClustering(cfg ClusterConfig) {
+	dht.cluster = newClusterHierarchy(dht, cfg)
+}
+
+// AdmitWrite is the admission-control check PutValue/Provide should make
+// before storing a record for key locally: it reports the cluster level the
+// write was admitted to, or ok == false if clustering is enabled and every
+// level the hierarchy knows about has already reached its ProviderThreshold
+// for key, meaning the write should be redirected to a peer further out
+// instead of stored here. With clustering disabled every write is admitted.
+//
+// Nothing calls it today (cluster_test.go exercises admitWriteClustered
+// directly): PutValue/Provide aren't part of this tree.
+func (dht *IpfsDHT) AdmitWrite(key string) (level string, ok bool) {
+	if dht.cluster == nil {
+		return "", true
+	}
+	level = dht.cluster.admitWriteClustered(key)
+	return level, level != ""
+}
+
+// observeRTT piggybacks on an RTT already measured by findPeerSingle to
+// keep the cluster hierarchy's per-level routing tables up to date. It is a
+// no-op when clustering hasn't been enabled.
+func (dht *IpfsDHT) observeRTT(p peer.ID, rtt time.Duration) {
+	if dht.cluster != nil {
+		dht.cluster.observeRTT(p, rtt)
+	}
+}
+
+// newClusterHierarchy builds the per-level routing tables described by cfg.
+// Every level except the outermost starts empty and is populated only by
+// RTT samples arriving through observeRTT. The outermost level is aliased
+// directly to dht.routingTable instead of getting its own empty table, so
+// it is always backed by the node's full, normally-populated global ring
+// rather than whatever subset happens to have been RTT-sampled so far —
+// matching DefaultClusterConfig's promise that the outermost level "falls
+// back to the full routing table."
+func newClusterHierarchy(d *IpfsDHT, cfg ClusterConfig) *clusterHierarchy {
+	h := &clusterHierarchy{dht: d}
+	for i, lvl := range cfg.Levels {
+		table := d.routingTable
+		if i != len(cfg.Levels)-1 {
+			table = kb.NewRoutingTable(d.bucketSize, kb.ConvertPeerID(d.self), time.Minute, d.peerstore)
+		}
+		h.levels = append(h.levels, &clusterLevelTable{
+			ClusterLevel: lvl,
+			routingTable: table,
+			providers:    make(map[string]int),
+		})
+	}
+	return h
+}
+
+// observeRTT admits p into every level whose RTTThreshold is at or above
+// the measured round-trip time, and evicts it from tighter levels it no
+// longer qualifies for. It is meant to be piggybacked on the RTT already
+// measured by findPeerSingle, not to trigger new round trips.
+func (h *clusterHierarchy) observeRTT(p peer.ID, rtt time.Duration) {
+	for _, lvl := range h.levels {
+		if lvl.RTTThreshold == 0 || rtt <= lvl.RTTThreshold {
+			lvl.routingTable.Update(p)
+		} else {
+			lvl.routingTable.Remove(p)
+		}
+	}
+}
+
+// closestPeersInLevel returns the level's own view of the peers nearest
+// key, or nil if the level's routing table has nothing to offer.
+func (lvl *clusterLevelTable) closestPeersInLevel(key string, count int) []peer.ID {
+	peers := lvl.routingTable.NearestPeers(kb.ConvertKey(key), count)
+	if len(peers) == 0 {
+		return nil
+	}
+	return peers
+}
+
+// admitWrite reports whether this level still has room to store another
+// provider/value record for key. A level with ProviderThreshold == 0 never
+// declines.
+func (lvl *clusterLevelTable) admitWrite(key string) bool {
+	if lvl.ProviderThreshold == 0 {
+		return true
+	}
+	lvl.mu.Lock()
+	defer lvl.mu.Unlock()
+	return lvl.providers[key] < lvl.ProviderThreshold
+}
+
+// recordWrite accounts for a provider/value record just stored for key at
+// this level.
+func (lvl *clusterLevelTable) recordWrite(key string) {
+	if lvl.ProviderThreshold == 0 {
+		return
+	}
+	lvl.mu.Lock()
+	defer lvl.mu.Unlock()
+	lvl.providers[key]++
+}
+
+// getClosestPeersClustered walks the hierarchy inward-to-outward (L0 ->
+// L1 -> L2, i.e. innermost to outermost as configured), returning the
+// first level's result that is at least half populated. This is the read
+// path: GetClosestPeers/Provide/FindProviders should consult the smallest
+// cluster first and only escalate when it comes up empty or thin. want is
+// normally the caller's bucket size.
+//
+// Every level, including the outermost, must clear the half-populated bar:
+// an under-populated result is never accepted just because it came from
+// the last level, so a caller whose cluster hierarchy genuinely has too
+// few peers falls through to the real, RPC-driven global lookup instead of
+// being handed a short list and told it's done.
+func (h *clusterHierarchy) getClosestPeersClustered(ctx context.Context, key string, want int) ([]peer.ID, string) {
+	for _, lvl := range h.levels {
+		peers := lvl.closestPeersInLevel(key, want)
+		if len(peers) >= want/2 && len(peers) > 0 {
+			return peers, lvl.Name
+		}
+	}
+	return nil, ""
+}
+
+// admitWriteClustered walks the hierarchy inward-to-outward (local -> ...
+// -> global) looking for the tightest cluster that still has capacity for
+// key, so that writes land in the smallest cluster that can take them and
+// only spill outward once a level is saturated. It returns the chosen
+// level's name, or "" if every level (including the unbounded outermost
+// one, which normally never happens) declined the write.
+func (h *clusterHierarchy) admitWriteClustered(key string) string {
+	for _, lvl := range h.levels {
+		if lvl.admitWrite(key) {
+			lvl.recordWrite(key)
+			return lvl.Name
+		}
+	}
+	return ""
+}
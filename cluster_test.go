@@ -0,0 +1,118 @@
+package dht
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	kb "github.com/libp2p/go-libp2p-kbucket"
+)
+
+// fakePeerTable is a minimal clusterPeerTable that just returns whatever
+// peers were seeded into it, so tests can drive getClosestPeersClustered
+// without a real *kb.RoutingTable and peerstore.
+type fakePeerTable struct {
+	peers []peer.ID
+}
+
+func (t *fakePeerTable) NearestPeers(kb.ID, int) []peer.ID { return t.peers }
+func (t *fakePeerTable) Update(peer.ID) error              { return nil }
+func (t *fakePeerTable) Remove(peer.ID)                    {}
+
+func TestGetClosestPeersClusteredStaysLocal(t *testing.T) {
+	local := &clusterLevelTable{
+		ClusterLevel: ClusterLevel{Name: "local"},
+		routingTable: &fakePeerTable{peers: []peer.ID{"local-a", "local-b", "local-c", "local-d"}},
+		providers:    make(map[string]int),
+	}
+	// The global level is present but deliberately near-empty: a
+	// locality-heavy workload should resolve from "local" and never need
+	// to consult it.
+	global := &clusterLevelTable{
+		ClusterLevel: ClusterLevel{Name: "global"},
+		routingTable: &fakePeerTable{peers: []peer.ID{"global-a"}},
+		providers:    make(map[string]int),
+	}
+
+	h := &clusterHierarchy{levels: []*clusterLevelTable{local, global}}
+
+	peers, level := h.getClosestPeersClustered(context.Background(), "some-key", 4)
+	if level != "local" {
+		t.Fatalf("expected lookup to resolve from the local cluster, resolved from %q instead", level)
+	}
+	if len(peers) != 4 {
+		t.Fatalf("expected all 4 local peers back, got %d", len(peers))
+	}
+	for _, p := range peers {
+		if p == "global-a" {
+			t.Fatalf("lookup escalated to the global ring even though the local cluster was fully populated")
+		}
+	}
+}
+
+func TestGetClosestPeersClusteredEscalatesWhenLocalIsThin(t *testing.T) {
+	local := &clusterLevelTable{
+		ClusterLevel: ClusterLevel{Name: "local"},
+		routingTable: &fakePeerTable{peers: []peer.ID{"local-a"}},
+		providers:    make(map[string]int),
+	}
+	global := &clusterLevelTable{
+		ClusterLevel: ClusterLevel{Name: "global"},
+		routingTable: &fakePeerTable{peers: []peer.ID{"global-a", "global-b", "global-c", "global-d"}},
+		providers:    make(map[string]int),
+	}
+
+	h := &clusterHierarchy{levels: []*clusterLevelTable{local, global}}
+
+	_, level := h.getClosestPeersClustered(context.Background(), "some-key", 4)
+	if level != "global" {
+		t.Fatalf("expected a thin local cluster to escalate to global, resolved from %q instead", level)
+	}
+}
+
+func TestGetClosestPeersClusteredFallsThroughWhenEveryLevelIsThin(t *testing.T) {
+	local := &clusterLevelTable{
+		ClusterLevel: ClusterLevel{Name: "local"},
+		routingTable: &fakePeerTable{peers: []peer.ID{"local-a"}},
+		providers:    make(map[string]int),
+	}
+	// The outermost level is thin too (e.g. a freshly-started node that
+	// hasn't populated its global ring yet): getClosestPeersClustered must
+	// not hand this back as a complete answer just because it's the last
+	// level, or a caller could get far fewer than bucketSize peers where
+	// the un-clustered path would have returned a full bucket.
+	global := &clusterLevelTable{
+		ClusterLevel: ClusterLevel{Name: "global"},
+		routingTable: &fakePeerTable{peers: []peer.ID{"global-a"}},
+		providers:    make(map[string]int),
+	}
+
+	h := &clusterHierarchy{levels: []*clusterLevelTable{local, global}}
+
+	peers, level := h.getClosestPeersClustered(context.Background(), "some-key", 4)
+	if level != "" || peers != nil {
+		t.Fatalf("expected a fully-thin hierarchy to fall through to the real lookup, got level %q peers %v", level, peers)
+	}
+}
+
+func TestAdmitWriteClusteredPrefersInnermostWithCapacity(t *testing.T) {
+	local := &clusterLevelTable{
+		ClusterLevel: ClusterLevel{Name: "local", ProviderThreshold: 1},
+		providers:    map[string]int{"hot-key": 1}, // already at capacity
+	}
+	regional := &clusterLevelTable{
+		ClusterLevel: ClusterLevel{Name: "regional", ProviderThreshold: 2},
+		providers:    make(map[string]int),
+	}
+
+	h := &clusterHierarchy{levels: []*clusterLevelTable{local, regional}}
+
+	level := h.admitWriteClustered("hot-key")
+	if level != "regional" {
+		t.Fatalf("expected write to be redirected to regional once local was saturated, got %q", level)
+	}
+	if regional.providers["hot-key"] != 1 {
+		t.Fatalf("expected admitted write to be recorded against regional")
+	}
+}
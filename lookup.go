@@ -4,15 +4,11 @@ import (
 	"context"
 	"fmt"
 	"strings"
-	"time"
 
 	"github.com/libp2p/go-libp2p-core/peer"
-	"github.com/libp2p/go-libp2p-core/routing"
 
 	"github.com/ipfs/go-cid"
 	logging "github.com/ipfs/go-log"
-	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
-	kb "github.com/libp2p/go-libp2p-kbucket"
 	"github.com/multiformats/go-base32"
 	"github.com/multiformats/go-multihash"
 )
@@ -66,67 +62,55 @@ func multihashLoggableKey(mh multihash.Multihash) logging.LoggableMap {
 }
 
 // Kademlia 'node lookup' operation. Returns a channel of the K closest peers
-// to the given key
+// to the given key, streamed as soon as each one is confirmed rather than
+// buffered until the lookup finishes. A peer sent early can later be pushed
+// out of the top K by closer ones, so the channel can carry more than K
+// peers.
+//
+// This is the resolution step Provide, FindProvidersAsync, GetValue and
+// PutValue all build their fan-out on, so configuring dht.disjointPaths
+// above 1 to opt into S/Kademlia-style routing, or enabling clustering via
+// EnableClustering, applies to every one of those operations, not just
+// direct callers of GetClosestPeers.
+//
+// The two are deliberately composed rather than one disabling the other:
+// the cluster hierarchy is consulted first regardless of disjointPaths,
+// since it is a cheap local-first optimization that short-circuits the
+// network lookup entirely whenever a nearby level is populated enough to
+// answer confidently. Only once the cluster is absent or every level falls
+// through to the real lookup does disjointPaths being > 1 select the
+// S/Kademlia disjoint-path lookup over the default single-path one; both of
+// those stream through the same bucketSize closestWindow semantics, so this
+// ordering only changes how peers are discovered, never whether results are
+// streamed or how they're deduplicated.
 func (dht *IpfsDHT) GetClosestPeers(ctx context.Context, key string) (<-chan peer.ID, error) {
-	e := logger.EventBegin(ctx, "getClosestPeers", loggableKey(key))
-	tablepeers := dht.routingTable.NearestPeers(kb.ConvertKey(key), AlphaValue)
-	if len(tablepeers) == 0 {
-		return nil, kb.ErrLookupFailure
-	}
-
-	out := make(chan peer.ID, dht.bucketSize)
-
-	// since the query doesnt actually pass our context down
-	// we have to hack this here. whyrusleeping isnt a huge fan of goprocess
-	parent := ctx
-	query := dht.newQuery(key, func(ctx context.Context, p peer.ID) (*dhtQueryResult, error) {
-		// For DHT query command
-		routing.PublishQueryEvent(parent, &routing.QueryEvent{
-			Type: routing.SendingQuery,
-			ID:   p,
-		})
-
-		pmes, err := dht.findPeerSingle(ctx, p, peer.ID(key))
-		if err != nil {
-			logger.Debugf("error getting closer peers: %s", err)
-			return nil, err
+	if dht.cluster != nil {
+		if peers, level := dht.cluster.getClosestPeersClustered(ctx, key, dht.bucketSize); len(peers) > 0 {
+			logger.Debugf("resolved %s from cluster level %q without the global ring", loggableKey(key), level)
+			out := make(chan peer.ID, len(peers))
+			for _, p := range peers {
+				out <- p
+			}
+			close(out)
+			return out, nil
 		}
-		peers := pb.PBPeersToPeerInfos(pmes.GetCloserPeers())
+	}
 
-		// For DHT query command
-		routing.PublishQueryEvent(parent, &routing.QueryEvent{
-			Type:      routing.PeerResponse,
-			ID:        p,
-			Responses: peers,
-		})
+	if d := dht.disjointPaths; d > 1 {
+		return dht.GetClosestPeersDisjoint(ctx, key, d)
+	}
 
-		return &dhtQueryResult{closerPeers: peers}, nil
-	})
+	stream, cancel, err := dht.GetClosestPeersExtended(ctx, key, dht.bucketSize)
+	if err != nil {
+		return nil, err
+	}
 
+	out := make(chan peer.ID, dht.bucketSize)
 	go func() {
-		defer close(out)
-		defer e.Done()
-		timedCtx, cancel := context.WithTimeout(ctx, time.Minute)
 		defer cancel()
-		// run it!
-		res, err := query.Run(timedCtx, tablepeers)
-		if err != nil {
-			logger.Debugf("closestPeers query run error: %s", err)
-		}
-
-		if res != nil && res.queriedSet != nil {
-			// refresh the cpl for this key as the query was successful
-			dht.routingTable.ResetCplRefreshedAtForID(kb.ConvertKey(key), time.Now())
-
-			sorted := kb.SortClosestPeers(res.queriedSet.Peers(), kb.ConvertKey(key))
-			l := len(sorted)
-			if l > dht.bucketSize {
-				sorted = sorted[:dht.bucketSize]
-			}
-
-			for _, p := range sorted {
-				out <- p
-			}
+		defer close(out)
+		for cp := range stream {
+			out <- cp.ID
 		}
 	}()
 
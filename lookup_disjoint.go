@@ -0,0 +1,199 @@
+package dht
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/routing"
+
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+	kb "github.com/libp2p/go-libp2p-kbucket"
+)
+
+// DefaultDisjointPaths is a path count for callers of
+// GetClosestPeersDisjoint. GetClosestPeers does not read it: it runs the
+// disjoint lookup only when dht.disjointPaths is above 1, with that many
+// paths.
+const DefaultDisjointPaths = 3
+
+// disjointPathTracker is the global blocklist shared by every path spawned
+// from a single GetClosestPeersDisjoint call. A peer is added to it the
+// moment any path is allowed to queue it, so the d lookups can never chase
+// the same peer even if two paths learn about it independently.
+type disjointPathTracker struct {
+	mu      sync.Mutex
+	claimed map[peer.ID]struct{}
+}
+
+func newDisjointPathTracker(seeds []peer.ID) *disjointPathTracker {
+	t := &disjointPathTracker{claimed: make(map[peer.ID]struct{}, len(seeds))}
+	for _, p := range seeds {
+		t.claimed[p] = struct{}{}
+	}
+	return t
+}
+
+// tryClaim reports whether p was not yet owned by another path, claiming it
+// for the caller's path as a side effect.
+func (t *disjointPathTracker) tryClaim(p peer.ID) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.claimed[p]; ok {
+		return false
+	}
+	t.claimed[p] = struct{}{}
+	return true
+}
+
+// partitionSeeds splits seeds round-robin into d buckets, so that no seed
+// is shared between the buckets: element i lands in bucket i%d. Buckets
+// are returned in the same order they'd be consulted (bucket 0 first).
+func partitionSeeds(seeds []peer.ID, d int) [][]peer.ID {
+	paths := make([][]peer.ID, d)
+	for i, p := range seeds {
+		pi := i % d
+		paths[pi] = append(paths[pi], p)
+	}
+	return paths
+}
+
+// GetClosestPeersDisjoint is an opt-in, S/Kademlia-style variant of
+// GetClosestPeers: instead of a single lookup over one shared candidate
+// set, it runs d independent lookups over disjoint peer sets and streams
+// their merged closest-K result, exactly like GetClosestPeersExtended does
+// for a single path. A malicious neighborhood now has to eclipse the key on
+// all d paths at once, rather than capturing a single shared bucket.
+//
+// d*AlphaValue seeds are pulled from the routing table and partitioned
+// round-robin into d buckets so no seed is shared between paths. Each path
+// then runs the existing single-path query logic against its own bucket,
+// consulting a blocklist shared across all paths so a peer discovered via
+// one path's closer-peers response can never be queued by another, and
+// folding every response into one shared closestWindow so a peer newly
+// inside the merged top-K is streamed to the caller as soon as any path
+// discovers it, rather than waiting for every path to finish. Passing d ==
+// 1 reproduces GetClosestPeers's classic, single-path behavior.
+func (dht *IpfsDHT) GetClosestPeersDisjoint(ctx context.Context, key string, d int) (<-chan peer.ID, error) {
+	if d < 1 {
+		d = 1
+	}
+
+	e := logger.EventBegin(ctx, "getClosestPeersDisjoint", loggableKey(key))
+
+	seeds := dht.routingTable.NearestPeers(kb.ConvertKey(key), d*AlphaValue)
+	if len(seeds) == 0 {
+		return nil, kb.ErrLookupFailure
+	}
+
+	paths := partitionSeeds(seeds, d)
+	tracker := newDisjointPathTracker(seeds)
+
+	lookupCtx, cancel := context.WithCancel(ctx)
+	out := make(chan peer.ID, dht.bucketSize)
+	parent := ctx
+
+	var mu sync.Mutex
+	win := newClosestWindow(key, dht.bucketSize, seeds)
+
+	var wg sync.WaitGroup
+	for i, ps := range paths {
+		if len(ps) == 0 {
+			continue
+		}
+
+		pathIndex, pathSeeds := i, ps
+		q := dht.newQuery(key, func(ctx context.Context, p peer.ID) (*dhtQueryResult, error) {
+			// For DHT query command
+			routing.PublishQueryEvent(parent, &routing.QueryEvent{
+				Type:  routing.SendingQuery,
+				ID:    p,
+				Extra: fmt.Sprintf("path:%d", pathIndex),
+			})
+
+			start := time.Now()
+			pmes, err := dht.findPeerSingle(ctx, p, peer.ID(key))
+			if err != nil {
+				logger.Debugf("error getting closer peers: %s", err)
+				return nil, err
+			}
+			rtt := time.Since(start)
+			dht.observeRTT(p, rtt)
+			peers := pb.PBPeersToPeerInfos(pmes.GetCloserPeers())
+
+			// Drop any peer another path already owns so the d lookups
+			// never end up sharing a pending peer.
+			claimed := make([]*peer.AddrInfo, 0, len(peers))
+			for _, pi := range peers {
+				if tracker.tryClaim(pi.ID) {
+					claimed = append(claimed, pi)
+				}
+			}
+			ids := make([]peer.ID, len(claimed))
+			for i, ai := range claimed {
+				ids[i] = ai.ID
+			}
+
+			// toEmit is computed and sent to out inside the same critical
+			// section: two paths' responses can arrive concurrently, and
+			// sending only after releasing the lock would let their sends
+			// race and reorder, or let a peer already marked emitted get
+			// silently dropped if the lookup is cancelled before its send
+			// runs.
+			mu.Lock()
+			toEmit, done := win.advance(p, rtt, ids)
+			for _, cp := range toEmit {
+				select {
+				case out <- cp.ID:
+				case <-lookupCtx.Done():
+					mu.Unlock()
+					return nil, lookupCtx.Err()
+				}
+			}
+			mu.Unlock()
+			if done {
+				cancel()
+			}
+
+			// For DHT query command
+			routing.PublishQueryEvent(parent, &routing.QueryEvent{
+				Type:      routing.PeerResponse,
+				ID:        p,
+				Responses: claimed,
+				Extra:     fmt.Sprintf("path:%d", pathIndex),
+			})
+
+			return &dhtQueryResult{closerPeers: claimed}, nil
+		})
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			timedCtx, timeoutCancel := context.WithTimeout(lookupCtx, time.Minute)
+			defer timeoutCancel()
+			_, err := q.Run(timedCtx, pathSeeds)
+			if err != nil && lookupCtx.Err() == nil {
+				logger.Debugf("closestPeers disjoint path %d query run error: %s", pathIndex, err)
+			}
+		}()
+	}
+
+	go func() {
+		defer close(out)
+		defer e.Done()
+		defer cancel()
+		wg.Wait()
+
+		mu.Lock()
+		resolved := len(win.emitted) > 0
+		mu.Unlock()
+		if resolved {
+			// refresh the cpl for this key as at least one path was successful
+			dht.routingTable.ResetCplRefreshedAtForID(kb.ConvertKey(key), time.Now())
+		}
+	}()
+
+	return out, nil
+}
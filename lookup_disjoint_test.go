@@ -0,0 +1,88 @@
+package dht
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestPartitionSeedsRoundRobinNoOverlap(t *testing.T) {
+	seeds := []peer.ID{"a", "b", "c", "d", "e", "f", "g"}
+	paths := partitionSeeds(seeds, 3)
+
+	if len(paths) != 3 {
+		t.Fatalf("expected 3 buckets, got %d", len(paths))
+	}
+
+	seen := make(map[peer.ID]int)
+	for _, bucket := range paths {
+		for _, p := range bucket {
+			seen[p]++
+		}
+	}
+	if len(seen) != len(seeds) {
+		t.Fatalf("expected all %d seeds to be partitioned exactly once, got %d distinct entries", len(seeds), len(seen))
+	}
+	for p, count := range seen {
+		if count != 1 {
+			t.Fatalf("seed %v assigned to %d buckets, want exactly 1", p, count)
+		}
+	}
+
+	want := [][]peer.ID{{"a", "d", "g"}, {"b", "e"}, {"c", "f"}}
+	for i, bucket := range paths {
+		if len(bucket) != len(want[i]) {
+			t.Fatalf("bucket %d: got %v, want %v", i, bucket, want[i])
+		}
+		for j, p := range bucket {
+			if p != want[i][j] {
+				t.Fatalf("bucket %d: got %v, want %v", i, bucket, want[i])
+			}
+		}
+	}
+}
+
+// TestDisjointPathTrackerExclusiveUnderConcurrency exercises the exact
+// property GetClosestPeersDisjoint depends on for its security guarantee:
+// even when many goroutines race to claim the same peer (representing
+// different paths independently learning about it), exactly one of them
+// wins.
+func TestDisjointPathTrackerExclusiveUnderConcurrency(t *testing.T) {
+	tracker := newDisjointPathTracker(nil)
+
+	const racers = 64
+	var wg sync.WaitGroup
+	wins := make([]bool, racers)
+	wg.Add(racers)
+	for i := 0; i < racers; i++ {
+		i := i
+		go func() {
+			defer wg.Done()
+			wins[i] = tracker.tryClaim("contested")
+		}()
+	}
+	wg.Wait()
+
+	claimedBy := 0
+	for _, w := range wins {
+		if w {
+			claimedBy++
+		}
+	}
+	if claimedBy != 1 {
+		t.Fatalf("expected exactly one goroutine to claim the contested peer, got %d", claimedBy)
+	}
+}
+
+func TestDisjointPathTrackerSeedsPreclaimed(t *testing.T) {
+	seeds := []peer.ID{"s1", "s2"}
+	tracker := newDisjointPathTracker(seeds)
+
+	if tracker.tryClaim("s1") {
+		t.Fatalf("expected a seed to already be claimed, so no path re-queues it as if newly discovered")
+	}
+	if !tracker.tryClaim("fresh") {
+		t.Fatalf("expected a peer outside the seed set to be claimable")
+	}
+}
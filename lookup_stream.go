@@ -0,0 +1,145 @@
+package dht
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/routing"
+
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+	kb "github.com/libp2p/go-libp2p-kbucket"
+)
+
+// ClosestPeer is one entry of a GetClosestPeersExtended result stream: a
+// peer that newly entered the query's top-K window, along with enough
+// observability data for callers like Provide fan-out or bitswap sessions
+// to make informed choices instead of waiting for the whole lookup to
+// finish.
+type ClosestPeer struct {
+	ID peer.ID
+
+	// RTT is the round trip it took the queried peer whose findPeerSingle
+	// response first returned ID to answer that request.
+	RTT time.Duration
+
+	// Hop is the index, within the seeds GetClosestPeersExtended started
+	// from, of the seed ID's discovery traces back to: a peer returned by a
+	// seed gets that seed's index, and a peer returned by any later peer
+	// inherits that peer's Hop.
+	Hop int
+}
+
+// GetClosestPeersExtended behaves like GetClosestPeers but streams each
+// peer onto the returned channel as soon as it newly enters the query's
+// top-K closest set, instead of buffering everything until query.Run
+// returns. The peers that enter the window on one findPeerSingle response
+// arrive in sorted order of XOR distance to key; a peer from a later
+// response can be closer than one already sent. A peer
+// already emitted is never re-emitted unless it moves within the top-K
+// window after previously falling out of it.
+//
+// The caller bounds the lookup with k: once k peers have been emitted, the
+// returned cancel func can be invoked to abort any outstanding
+// findPeerSingle RPCs and stop the lookup early. Cancelling ctx has the
+// same effect.
+func (dht *IpfsDHT) GetClosestPeersExtended(ctx context.Context, key string, k int) (<-chan *ClosestPeer, func(), error) {
+	if k <= 0 {
+		k = dht.bucketSize
+	}
+
+	e := logger.EventBegin(ctx, "getClosestPeersExtended", loggableKey(key))
+
+	tablepeers := dht.routingTable.NearestPeers(kb.ConvertKey(key), AlphaValue)
+	if len(tablepeers) == 0 {
+		return nil, func() {}, kb.ErrLookupFailure
+	}
+
+	lookupCtx, cancel := context.WithCancel(ctx)
+	out := make(chan *ClosestPeer, dht.bucketSize)
+	parent := ctx
+
+	var mu sync.Mutex
+	win := newClosestWindow(key, k, tablepeers)
+
+	query := dht.newQuery(key, func(ctx context.Context, p peer.ID) (*dhtQueryResult, error) {
+		start := time.Now()
+
+		routing.PublishQueryEvent(parent, &routing.QueryEvent{
+			Type: routing.SendingQuery,
+			ID:   p,
+		})
+
+		pmes, err := dht.findPeerSingle(ctx, p, peer.ID(key))
+		if err != nil {
+			logger.Debugf("error getting closer peers: %s", err)
+			return nil, err
+		}
+		rtt := time.Since(start)
+		dht.observeRTT(p, rtt)
+		peers := pb.PBPeersToPeerInfos(pmes.GetCloserPeers())
+
+		routing.PublishQueryEvent(parent, &routing.QueryEvent{
+			Type:      routing.PeerResponse,
+			ID:        p,
+			Responses: peers,
+		})
+
+		ids := make([]peer.ID, len(peers))
+		for i, ai := range peers {
+			ids[i] = ai.ID
+		}
+
+		// toEmit is computed and sent to out inside the same critical
+		// section: two findPeerSingle responses can be in flight
+		// concurrently, and sending only after releasing the lock would let
+		// their sends race and interleave (breaking the sorted order within
+		// each response's batch), or let a peer already marked emitted get silently,
+		// permanently dropped if a concurrent caller cancels before its send
+		// runs.
+		mu.Lock()
+		toEmit, done := win.advance(p, rtt, ids)
+		for _, cp := range toEmit {
+			select {
+			case out <- cp:
+			case <-lookupCtx.Done():
+				mu.Unlock()
+				return nil, lookupCtx.Err()
+			}
+		}
+		mu.Unlock()
+
+		if done {
+			cancel()
+		}
+
+		return &dhtQueryResult{closerPeers: peers}, nil
+	})
+
+	go func() {
+		defer close(out)
+		defer e.Done()
+		timedCtx, timeoutCancel := context.WithTimeout(lookupCtx, time.Minute)
+		defer timeoutCancel()
+
+		res, err := query.Run(timedCtx, tablepeers)
+		if err != nil && lookupCtx.Err() == nil {
+			logger.Debugf("closestPeersExtended query run error: %s", err)
+		}
+		if res != nil && res.queriedSet != nil {
+			dht.routingTable.ResetCplRefreshedAtForID(kb.ConvertKey(key), time.Now())
+		}
+	}()
+
+	return out, func() { cancel() }, nil
+}
+
+func containsPeer(ps []peer.ID, p peer.ID) bool {
+	for _, x := range ps {
+		if x == p {
+			return true
+		}
+	}
+	return false
+}